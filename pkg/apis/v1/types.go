@@ -0,0 +1,231 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	// GroupName is the API group the Queue resource is served under.
+	GroupName = "arbitrator.incubator.k8s.io"
+
+	// QueuePlural is the plural name of the Queue resource, used to build
+	// the REST path for the Queue third-party/custom resource.
+	QueuePlural = "queues"
+
+	// QueueKind is the kind name of the Queue resource.
+	QueueKind = "Queue"
+
+	// ManagedByLabel holds the owning Queue's name on every ResourceQuota the
+	// controller creates or adopts. It is read back to recognise a queue's
+	// own quotas and to re-enqueue the owning queue when such a quota changes;
+	// adoption itself goes by AdoptAnnotation.
+	ManagedByLabel = GroupName + "/queue"
+)
+
+// ResourceList is a per-resource-name vector of quantities, e.g. cpu/memory
+// or an extended resource such as nvidia.com/gpu.
+type ResourceList struct {
+	Resources map[string]resource.Quantity `json:"resources,omitempty"`
+}
+
+// QueueSpec describes the desired state of a Queue.
+type QueueSpec struct {
+	// Weight is used for proportional sharing of slack capacity amongst
+	// sibling queues; queues without an explicit weight default to 1.
+	Weight int32 `json:"weight,omitempty"`
+
+	// Min is the guaranteed resource vector for this queue. The queue is
+	// always entitled to Min, even when sibling queues are under pressure.
+	Min ResourceList `json:"min,omitempty"`
+
+	// Max is the upper bound this queue may grow to by borrowing unused
+	// capacity from sibling queues. A queue never holds more than Max.
+	Max ResourceList `json:"max,omitempty"`
+
+	// Parent is the name of the enclosing Queue in the hierarchy, if any.
+	// The parent's Allocated is split among its children proportional to
+	// each child's Weight, raised to the child's Min and capped at its Max;
+	// capacity a capped child does not take is not passed to its siblings.
+	// When the children's Max values sum to more than the parent's
+	// Allocated, the parent gets an Overcommit condition.
+	Parent string `json:"parent,omitempty"`
+
+	// ResourceQuotaName selects the ResourceQuota this queue manages by
+	// name. When empty, it defaults to "quota-<queue name>".
+	ResourceQuotaName string `json:"resourceQuotaName,omitempty"`
+
+	// NamespaceSelector, when set, lets a single queue back several team
+	// namespaces: every namespace it matches gets its own managed
+	// ResourceQuota (named ResourceQuotaName, or the default), and the
+	// queue's allocation is split across all of them. When nil, the queue
+	// only manages a quota in its own Namespace.
+	NamespaceSelector *meta_v1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// SplitStrategy picks how the queue's allocation is divided across
+	// multiple matched quotas. Defaults to QuotaSplitEqual.
+	SplitStrategy QuotaSplitStrategy `json:"splitStrategy,omitempty"`
+}
+
+// QuotaSplitStrategy names a pluggable way of dividing one queue's
+// allocation across the ResourceQuota objects it manages.
+type QuotaSplitStrategy string
+
+const (
+	// QuotaSplitEqual divides the allocation evenly across every matched
+	// namespace's quota.
+	QuotaSplitEqual QuotaSplitStrategy = "Equal"
+
+	// QuotaSplitWeighted divides the allocation proportional to each
+	// matched namespace's "arbitrator.incubator.k8s.io/weight" annotation
+	// (defaulting to 1 when absent).
+	QuotaSplitWeighted QuotaSplitStrategy = "Weighted"
+)
+
+// NamespaceWeightAnnotation is read from a matched namespace when a
+// queue's SplitStrategy is QuotaSplitWeighted.
+const NamespaceWeightAnnotation = GroupName + "/weight"
+
+// AdoptAnnotation, when set on a pre-existing ResourceQuota by a user, has
+// the controller adopt that quota into the named Queue instead of
+// refusing to act because the namespace already has a quota.
+const AdoptAnnotation = GroupName + "/adopt"
+
+// QueueConditionType is the type of a QueueCondition.
+type QueueConditionType string
+
+const (
+	// QueueConditionOvercommit is set to true on a parent queue when the
+	// sum of its children's Max exceeds the capacity it can hand down, and
+	// on a queue whose parent chain forms a cycle.
+	QueueConditionOvercommit QueueConditionType = "Overcommit"
+)
+
+// QueueCondition is an observation about a Queue's state.
+type QueueCondition struct {
+	Type               QueueConditionType `json:"type"`
+	Status             v1.ConditionStatus `json:"status"`
+	LastTransitionTime meta_v1.Time       `json:"lastTransitionTime,omitempty"`
+	Reason             string             `json:"reason,omitempty"`
+	Message            string             `json:"message,omitempty"`
+}
+
+// QueueStatus describes the observed state of a Queue.
+type QueueStatus struct {
+	// Allocated is the effective hard-limit currently enforced for this
+	// queue, after min/max borrowing has been resolved.
+	Allocated ResourceList `json:"allocated,omitempty"`
+
+	// Used is the resource vector currently consumed by pods running
+	// under this queue's namespace.
+	Used ResourceList `json:"used,omitempty"`
+
+	// Min mirrors Spec.Min for convenience of observers that only read status.
+	Min ResourceList `json:"min,omitempty"`
+
+	// Max mirrors Spec.Max for convenience of observers that only read status.
+	Max ResourceList `json:"max,omitempty"`
+
+	// Borrowed is the resource vector this queue is currently borrowing
+	// from sibling queues, on top of its own Min.
+	Borrowed ResourceList `json:"borrowed,omitempty"`
+
+	// Conditions records observations about this queue, such as a child
+	// overcommitting its parent's capacity.
+	Conditions []QueueCondition `json:"conditions,omitempty"`
+}
+
+// Queue is a collection of PodGroups/Pods sharing a slice of cluster
+// resources, enforced through a managed ResourceQuota.
+type Queue struct {
+	meta_v1.TypeMeta   `json:",inline"`
+	meta_v1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   QueueSpec   `json:"spec,omitempty"`
+	Status QueueStatus `json:"status,omitempty"`
+}
+
+// QueueList is a list of Queue resources.
+type QueueList struct {
+	meta_v1.TypeMeta `json:",inline"`
+	meta_v1.ListMeta `json:"metadata,omitempty"`
+
+	Items []Queue `json:"items"`
+}
+
+// DeepCopyObject implements runtime.Object.
+func (q *Queue) DeepCopyObject() runtime.Object {
+	if q == nil {
+		return nil
+	}
+	out := new(Queue)
+	*out = *q
+	out.ObjectMeta = *q.ObjectMeta.DeepCopy()
+	out.Spec.Min = deepCopyResourceList(q.Spec.Min)
+	out.Spec.Max = deepCopyResourceList(q.Spec.Max)
+	if q.Spec.NamespaceSelector != nil {
+		out.Spec.NamespaceSelector = q.Spec.NamespaceSelector.DeepCopy()
+	}
+
+	// Status is mutated in place by computeElasticAllocation/applyHierarchy
+	// on every copy handed out by listQueuesFromCache; every field here must
+	// be deep-copied, or those writers alias (and race on) the informer
+	// cache's own object.
+	out.Status.Allocated = deepCopyResourceList(q.Status.Allocated)
+	out.Status.Used = deepCopyResourceList(q.Status.Used)
+	out.Status.Min = deepCopyResourceList(q.Status.Min)
+	out.Status.Max = deepCopyResourceList(q.Status.Max)
+	out.Status.Borrowed = deepCopyResourceList(q.Status.Borrowed)
+	if q.Status.Conditions != nil {
+		out.Status.Conditions = make([]QueueCondition, len(q.Status.Conditions))
+		copy(out.Status.Conditions, q.Status.Conditions)
+	}
+
+	return out
+}
+
+func deepCopyResourceList(rl ResourceList) ResourceList {
+	if rl.Resources == nil {
+		return ResourceList{}
+	}
+	out := ResourceList{Resources: make(map[string]resource.Quantity, len(rl.Resources))}
+	for k, v := range rl.Resources {
+		out.Resources[k] = v.DeepCopy()
+	}
+	return out
+}
+
+// DeepCopyObject implements runtime.Object.
+func (ql *QueueList) DeepCopyObject() runtime.Object {
+	if ql == nil {
+		return nil
+	}
+	out := new(QueueList)
+	out.TypeMeta = ql.TypeMeta
+	out.ListMeta = ql.ListMeta
+	if ql.Items != nil {
+		out.Items = make([]Queue, len(ql.Items))
+		for i := range ql.Items {
+			out.Items[i] = *ql.Items[i].DeepCopyObject().(*Queue)
+		}
+	}
+	return out
+}
@@ -17,6 +17,8 @@ limitations under the License.
 package controller
 
 import (
+	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/golang/glog"
@@ -26,22 +28,53 @@ import (
 	arbclient "github.com/kubernetes-incubator/kube-arbitrator/pkg/client/informers/v1"
 
 	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	coreinformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
 )
 
+// compute resources that are projected through limits.* rather than
+// requests.*-only, mirroring upstream ResourceQuota conventions.
+var computeResources = map[string]bool{"cpu": true, "memory": true}
+
 type quotaManager struct {
 	config        *rest.Config
 	queueInformer arbclient.QueueInformer
+
+	// rqInformer watches ResourceQuota objects cluster-wide so that an
+	// external edit to a managed quota re-enqueues the owning queue
+	// instead of waiting for the next resync.
+	rqInformer coreinformers.ResourceQuotaInformer
+
+	// podInformer backs computeUsed with a namespace-indexed local cache, so
+	// a sync reads cluster usage without ever issuing a live Pods().List()
+	// call against the API server.
+	podInformer coreinformers.PodInformer
+
+	// workqueue holds namespace/name keys of queues that need a
+	// reconcile, rate-limited with exponential backoff on repeated errors.
+	workqueue workqueue.RateLimitingInterface
+
+	resyncPeriod time.Duration
 }
 
-func NewQuotaManager(config *rest.Config) *quotaManager {
+// NewQuotaManager builds a quotaManager. resyncPeriod controls how often
+// every queue is re-enqueued even without any observed change, as a safety
+// net against missed events.
+func NewQuotaManager(config *rest.Config, resyncPeriod time.Duration) *quotaManager {
 	qm := &quotaManager{
-		config: config,
+		config:       config,
+		resyncPeriod: resyncPeriod,
+		workqueue:    workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
 	}
 
 	queueClient, _, err := client.NewClient(config)
@@ -49,7 +82,7 @@ func NewQuotaManager(config *rest.Config) *quotaManager {
 		panic(err)
 	}
 
-	sharedInformerFactory := informerfactory.NewSharedInformerFactory(queueClient, 0)
+	sharedInformerFactory := informerfactory.NewSharedInformerFactory(queueClient, resyncPeriod)
 	// create informer for queue information
 	qm.queueInformer = sharedInformerFactory.Queue().Queues()
 	qm.queueInformer.Informer().AddEventHandler(
@@ -64,76 +97,744 @@ func NewQuotaManager(config *rest.Config) *quotaManager {
 				}
 			},
 			Handler: cache.ResourceEventHandlerFuncs{
-				AddFunc:    qm.AddQueue,
-				DeleteFunc: qm.DeleteQueue,
+				AddFunc: func(obj interface{}) {
+					qm.AddQueue(obj)
+					qm.enqueue(obj)
+				},
+				UpdateFunc: func(old, new interface{}) {
+					qm.enqueue(new)
+				},
+				DeleteFunc: func(obj interface{}) {
+					qm.DeleteQueue(obj)
+					qm.enqueue(obj)
+				},
 			},
 		})
 
+	cs := kubernetes.NewForConfigOrDie(config)
+	coreInformerFactory := informers.NewSharedInformerFactory(cs, resyncPeriod)
+	qm.rqInformer = coreInformerFactory.Core().V1().ResourceQuotas()
+	qm.rqInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    qm.enqueueOwningQueue,
+		UpdateFunc: func(old, new interface{}) { qm.enqueueOwningQueue(new) },
+		DeleteFunc: qm.enqueueOwningQueue,
+	})
+
+	qm.podInformer = coreInformerFactory.Core().V1().Pods()
+	qm.podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    qm.enqueueQueuesForPod,
+		UpdateFunc: func(old, new interface{}) { qm.enqueueQueuesForPod(new) },
+		DeleteFunc: qm.enqueueQueuesForPod,
+	})
+
 	return qm
 }
 
-func (qm *quotaManager) Run(stopCh <-chan struct{}) {
+// enqueue converts a Queue object into a namespace/name key and schedules
+// it for reconciliation.
+func (qm *quotaManager) enqueue(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	qm.workqueue.Add(key)
+}
+
+// enqueueOwningQueue re-enqueues the queue a ResourceQuota belongs to, so
+// external edits to the managed quota get reconciled back: the queue named
+// by its ManagedByLabel, or, for a quota without that label, every queue in
+// the quota's namespace.
+func (qm *quotaManager) enqueueOwningQueue(obj interface{}) {
+	rq, ok := obj.(*v1.ResourceQuota)
+	if !ok {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			rq, ok = tombstone.Obj.(*v1.ResourceQuota)
+			if !ok {
+				return
+			}
+		} else {
+			return
+		}
+	}
+
+	if queueName, ok := rq.Labels[arbv1.ManagedByLabel]; ok {
+		for _, obj := range qm.queueInformer.Informer().GetStore().List() {
+			if queue, ok := obj.(*arbv1.Queue); ok && queue.Name == queueName {
+				qm.workqueue.Add(queue.Namespace + "/" + queue.Name)
+				return
+			}
+		}
+		return
+	}
+
+	// Unmanaged quota: fall back to matching by namespace, so a queue with
+	// no NamespaceSelector still notices edits to its own namespace's quota.
+	for _, obj := range qm.queueInformer.Informer().GetStore().List() {
+		if queue, ok := obj.(*arbv1.Queue); ok && queue.Namespace == rq.Namespace {
+			qm.workqueue.Add(queue.Namespace + "/" + queue.Name)
+		}
+	}
+}
+
+// enqueueQueuesForPod re-enqueues every queue whose own Namespace matches a
+// changed pod's namespace, so computeUsed's view of that queue's usage is
+// refreshed on the next sync. Queues that only reach a namespace through a
+// NamespaceSelector are still picked up by resyncPeriod, the same safety net
+// enqueueOwningQueue's namespace fallback already relies on.
+func (qm *quotaManager) enqueueQueuesForPod(obj interface{}) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			pod, ok = tombstone.Obj.(*v1.Pod)
+			if !ok {
+				return
+			}
+		} else {
+			return
+		}
+	}
+
+	for _, obj := range qm.queueInformer.Informer().GetStore().List() {
+		if queue, ok := obj.(*arbv1.Queue); ok && queue.Namespace == pod.Namespace {
+			qm.workqueue.Add(queue.Namespace + "/" + queue.Name)
+		}
+	}
+}
+
+// QueueInformer exposes the shared queue informer so that other
+// components serving requests against the same cluster state (e.g. the
+// admission webhook in pkg/webhook) can read from the same lister/cache
+// the reconciler uses, instead of standing up a second watch.
+func (qm *quotaManager) QueueInformer() arbclient.QueueInformer {
+	return qm.queueInformer
+}
+
+// Run starts the informers, waits for their caches to sync, then launches
+// `workers` goroutines draining the workqueue until stopCh is closed.
+func (qm *quotaManager) Run(workers int, stopCh <-chan struct{}) error {
+	defer runtime.HandleCrash()
+	defer qm.workqueue.ShutDown()
+
 	go qm.queueInformer.Informer().Run(stopCh)
-	wait.Until(qm.runOnce, 500*time.Millisecond, stopCh)
+	go qm.rqInformer.Informer().Run(stopCh)
+	go qm.podInformer.Informer().Run(stopCh)
+
+	if !cache.WaitForCacheSync(stopCh, qm.queueInformer.Informer().HasSynced, qm.rqInformer.Informer().HasSynced, qm.podInformer.Informer().HasSynced) {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	for i := 0; i < workers; i++ {
+		go wait.Until(qm.runWorker, time.Second, stopCh)
+	}
+
+	<-stopCh
+	return nil
 }
 
-// run get request from queue and update to Quota
-func (qm *quotaManager) runOnce() {
-	queues, err := qm.fetchAllQueue()
+func (qm *quotaManager) runWorker() {
+	for qm.processNextWorkItem() {
+	}
+}
+
+// processNextWorkItem pops a single queue key off the workqueue and
+// reconciles it, retrying with exponential backoff on failure.
+func (qm *quotaManager) processNextWorkItem() bool {
+	key, shutdown := qm.workqueue.Get()
+	if shutdown {
+		return false
+	}
+	defer qm.workqueue.Done(key)
+
+	if err := qm.syncHandler(key.(string)); err != nil {
+		qm.workqueue.AddRateLimited(key)
+		glog.Errorf("Failed to sync queue %q, requeuing: %#v", key, err)
+		return true
+	}
+
+	qm.workqueue.Forget(key)
+	return true
+}
+
+// elasticResourceNames returns every resource name declared in any queue's
+// Min or Max, so the elastic borrowing model and hierarchy propagation cover
+// extended resources (GPU, hugepages, a CRD-backed scalar) the moment a
+// queue declares them, instead of a fixed cpu/memory list. cpu and memory
+// are always included so legacy queues that only set Min/Max for compute
+// resources keep behaving exactly as before.
+func elasticResourceNames(queues []arbv1.Queue) []string {
+	names := map[string]bool{"cpu": true, "memory": true}
+	for _, queue := range queues {
+		for name := range queue.Spec.Min.Resources {
+			names[name] = true
+		}
+		for name := range queue.Spec.Max.Resources {
+			names[name] = true
+		}
+	}
+
+	result := make([]string, 0, len(names))
+	for name := range names {
+		result = append(result, name)
+	}
+	return result
+}
+
+// syncHandler reconciles a single namespace/name queue key, using the
+// informer listers (rather than a live List() call) for both the queue
+// itself and its siblings, which are still needed to resolve elastic
+// borrowing and hierarchy propagation.
+func (qm *quotaManager) syncHandler(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
-		glog.Error("Fail to fetch all queue info")
-		return
+		return err
 	}
 
-	qm.updateQuotas(queues)
+	queues := qm.listQueuesFromCache()
+
+	cs := kubernetes.NewForConfigOrDie(qm.config)
+	qm.computeUsed(queues)
+	qm.computeElasticAllocation(queues)
+	qm.applyHierarchy(queues)
+
+	for i := range queues {
+		if queues[i].Namespace == namespace && queues[i].Name == name {
+			return qm.reconcileQueue(cs, &queues[i])
+		}
+	}
+
+	// The queue no longer exists; nothing left to reconcile for this key.
+	return nil
 }
 
-func (qm *quotaManager) fetchAllQueue() ([]arbv1.Queue, error) {
-	queueClient, _, err := client.NewClient(qm.config)
+// listQueuesFromCache reads every known Queue out of the informer's local
+// store, avoiding a live List() call against the API server. It hands back
+// deep copies: the store's objects are shared with every other reader
+// (other syncHandler goroutines, the admission webhook's findQueue), and
+// computeElasticAllocation/applyHierarchy write into the returned queues'
+// Resources maps in place, so a shallow copy here would mutate the
+// informer cache's own objects out from under concurrent readers.
+func (qm *quotaManager) listQueuesFromCache() []arbv1.Queue {
+	items := qm.queueInformer.Informer().GetStore().List()
+	queues := make([]arbv1.Queue, 0, len(items))
+	for _, obj := range items {
+		if queue, ok := obj.(*arbv1.Queue); ok {
+			queues = append(queues, *queue.DeepCopyObject().(*arbv1.Queue))
+		}
+	}
+	return queues
+}
+
+// quotaNameFor returns the ResourceQuota name a queue manages: its
+// explicit ResourceQuotaName, or the "quota-<queue name>" default.
+func quotaNameFor(queue *arbv1.Queue) string {
+	if queue.Spec.ResourceQuotaName != "" {
+		return queue.Spec.ResourceQuotaName
+	}
+	return "quota-" + queue.Name
+}
+
+// targetNamespaces resolves the namespaces a queue backs: just its own
+// Namespace by default, or every namespace matching its NamespaceSelector,
+// so one queue can govern several team namespaces at once.
+func (qm *quotaManager) targetNamespaces(cs kubernetes.Interface, queue *arbv1.Queue) ([]v1.Namespace, error) {
+	if queue.Spec.NamespaceSelector == nil {
+		ns, err := cs.CoreV1().Namespaces().Get(queue.Namespace, meta_v1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return []v1.Namespace{*ns}, nil
+	}
+
+	selector, err := meta_v1.LabelSelectorAsSelector(queue.Spec.NamespaceSelector)
 	if err != nil {
 		return nil, err
 	}
 
-	queueList := arbv1.QueueList{}
-	err = queueClient.Get().Resource(arbv1.QueuePlural).Do().Into(&queueList)
+	nsList, err := cs.CoreV1().Namespaces().List(meta_v1.ListOptions{LabelSelector: selector.String()})
 	if err != nil {
 		return nil, err
 	}
+	return nsList.Items, nil
+}
+
+// splitAllocation divides a queue's allocated resource vector across the
+// namespaces it backs, either evenly or proportional to each namespace's
+// weight annotation, per the queue's SplitStrategy.
+func splitAllocation(allocated map[string]resource.Quantity, namespaces []v1.Namespace, strategy arbv1.QuotaSplitStrategy) map[string]map[string]resource.Quantity {
+	weights := make([]int64, len(namespaces))
+	var totalWeight int64
+	for i, ns := range namespaces {
+		w := int64(1)
+		if strategy == arbv1.QuotaSplitWeighted {
+			if raw, ok := ns.Annotations[arbv1.NamespaceWeightAnnotation]; ok {
+				if parsed, err := strconv.ParseInt(raw, 10, 64); err == nil && parsed > 0 {
+					w = parsed
+				}
+			}
+		}
+		weights[i] = w
+		totalWeight += w
+	}
 
-	return queueList.Items, nil
+	result := make(map[string]map[string]resource.Quantity, len(namespaces))
+	for i, ns := range namespaces {
+		share := make(map[string]resource.Quantity, len(allocated))
+		for name, quantity := range allocated {
+			share[name] = *resource.NewMilliQuantity(quantity.MilliValue()*weights[i]/totalWeight, quantity.Format)
+		}
+		result[ns.Name] = share
+	}
+	return result
 }
 
-func (qm *quotaManager) updateQuotas(queues []arbv1.Queue) {
-	cs := kubernetes.NewForConfigOrDie(qm.config)
+// reconcileQueue pushes one queue's resolved allocation onto every
+// ResourceQuota it manages (one per matched namespace) and its own status
+// subresource.
+func (qm *quotaManager) reconcileQueue(cs kubernetes.Interface, queue *arbv1.Queue) error {
+	namespaces, err := qm.targetNamespaces(cs, queue)
+	if err != nil {
+		glog.Errorf("Failed to resolve target namespaces for queue %s, %#v", queue.Name, err)
+		return err
+	}
 
-	for _, queue := range queues {
-		rqController := cs.CoreV1().ResourceQuotas(queue.Namespace)
+	strategy := queue.Spec.SplitStrategy
+	if strategy == "" {
+		strategy = arbv1.QuotaSplitEqual
+	}
+	splits := splitAllocation(queue.Status.Allocated.Resources, namespaces, strategy)
 
-		var options meta_v1.ListOptions
-		rqList, err := rqController.List(options)
-		if err != nil || len(rqList.Items) != 1 {
-			glog.V(4).Infof("There are %d quotas under namespace %s, queue %s, err %#v", len(rqList.Items), queue.Namespace, queue.Name, err)
-			continue
+	for _, ns := range namespaces {
+		if err := qm.reconcileNamespaceQuota(cs, queue, ns.Name, splits[ns.Name]); err != nil {
+			glog.Errorf("Failed to reconcile quota %s in namespace %s for queue %s, %#v", quotaNameFor(queue), ns.Name, queue.Name, err)
+			return err
+		}
+	}
+
+	if err := qm.updateQueueStatus(queue); err != nil {
+		glog.Errorf("Failed to update status of queue %s, %#v", queue.Name, err)
+		return err
+	}
+
+	return nil
+}
+
+// reconcileNamespaceQuota creates, adopts, or updates the single
+// ResourceQuota a queue manages in one namespace.
+func (qm *quotaManager) reconcileNamespaceQuota(cs kubernetes.Interface, queue *arbv1.Queue, namespace string, allocated map[string]resource.Quantity) error {
+	rqController := cs.CoreV1().ResourceQuotas(namespace)
+	name := quotaNameFor(queue)
+
+	rq, err := rqController.Get(name, meta_v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return qm.createManagedQuota(rqController, queue, name, allocated)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !ownedByQueue(rq, queue) {
+		if rq.Annotations[arbv1.AdoptAnnotation] != queue.Name {
+			return fmt.Errorf("quota %s/%s exists and is not managed by queue %s; add the %q annotation to adopt it", namespace, name, queue.Name, arbv1.AdoptAnnotation)
+		}
+		glog.V(2).Infof("Adopting unmanaged quota %s/%s into queue %s", namespace, name, queue.Name)
+	}
+
+	updatedRq := rq.DeepCopy()
+	setQueueOwnership(updatedRq, queue)
+	if updatedRq.Spec.Hard == nil {
+		// A legally-constructed quota (in particular one we're adopting via
+		// AdoptAnnotation) may have no hard limits at all yet; without this,
+		// projectAllocatedResources would panic writing into a nil map.
+		updatedRq.Spec.Hard = map[v1.ResourceName]resource.Quantity{}
+	}
+	projectAllocatedResources(updatedRq, allocated)
+
+	_, err = rqController.Update(updatedRq)
+	return err
+}
+
+func (qm *quotaManager) createManagedQuota(rqController corev1client.ResourceQuotaInterface, queue *arbv1.Queue, name string, allocated map[string]resource.Quantity) error {
+	newRq := &v1.ResourceQuota{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name: name,
+		},
+		Spec: v1.ResourceQuotaSpec{
+			Hard: map[v1.ResourceName]resource.Quantity{},
+		},
+	}
+	setQueueOwnership(newRq, queue)
+	projectAllocatedResources(newRq, allocated)
+
+	_, err := rqController.Create(newRq)
+	return err
+}
+
+// ownedByQueue reports whether rq was created (and is still owned) by
+// queue, via the owner-reference set at creation time or the queue's name
+// in ManagedByLabel. This replaces the
+// old len(rqList.Items) != 1 heuristic, so garbage-collection of quotas
+// created by AddQueue is automatic when the owning Queue is deleted.
+func ownedByQueue(rq *v1.ResourceQuota, queue *arbv1.Queue) bool {
+	for _, ref := range rq.OwnerReferences {
+		if ref.Kind == arbv1.QueueKind && ref.Name == queue.Name {
+			return true
 		}
+	}
+	return rq.Labels[arbv1.ManagedByLabel] == queue.Name
+}
 
-		updatedRq := rqList.Items[0].DeepCopy()
-		if cpuQuantity, ok := queue.Status.Allocated.Resources["cpu"]; ok {
-			updatedRq.Spec.Hard["limits.cpu"] = cpuQuantity
-			updatedRq.Spec.Hard["requests.cpu"] = cpuQuantity
+func setQueueOwnership(rq *v1.ResourceQuota, queue *arbv1.Queue) {
+	if rq.Labels == nil {
+		rq.Labels = map[string]string{}
+	}
+	rq.Labels[arbv1.ManagedByLabel] = queue.Name
+
+	controller := true
+	ownerRef := meta_v1.OwnerReference{
+		APIVersion: arbv1.GroupName + "/v1",
+		Kind:       arbv1.QueueKind,
+		Name:       queue.Name,
+		UID:        queue.UID,
+		Controller: &controller,
+	}
+	for i, ref := range rq.OwnerReferences {
+		if ref.Kind == arbv1.QueueKind && ref.Name == queue.Name {
+			rq.OwnerReferences[i] = ownerRef
+			return
 		}
-		if memoryQuantity, ok := queue.Status.Allocated.Resources["memory"]; ok {
-			updatedRq.Spec.Hard["limits.memory"] = memoryQuantity
-			updatedRq.Spec.Hard["requests.memory"] = memoryQuantity
+	}
+	rq.OwnerReferences = append(rq.OwnerReferences, ownerRef)
+}
+
+// computeUsed populates queue.Status.Used by summing container resource
+// requests for the pods running in each queue's namespace, read from
+// podInformer's namespace-indexed local cache rather than a live
+// Pods().List() call, so a sync never costs an API-server round trip per
+// queue.
+func (qm *quotaManager) computeUsed(queues []arbv1.Queue) {
+	for i := range queues {
+		queue := &queues[i]
+		used := map[string]resource.Quantity{
+			"cpu":    resource.MustParse("0"),
+			"memory": resource.MustParse("0"),
 		}
 
-		_, err = rqController.Update(updatedRq)
+		pods, err := qm.podInformer.Informer().GetIndexer().ByIndex(cache.NamespaceIndex, queue.Namespace)
 		if err != nil {
-			glog.Errorf("Failed to update resource quota %s, %#v", updatedRq.Name, err)
+			glog.Errorf("Failed to list cached pods in namespace %s for queue %s, %#v", queue.Namespace, queue.Name, err)
+			continue
+		}
+
+		for _, obj := range pods {
+			pod, ok := obj.(*v1.Pod)
+			if !ok {
+				continue
+			}
+			if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+				continue
+			}
+			for _, c := range pod.Spec.Containers {
+				for name, quantity := range c.Resources.Requests {
+					cur := used[string(name)]
+					cur.Add(quantity)
+					used[string(name)] = cur
+				}
+			}
+		}
+
+		queue.Status.Used = arbv1.ResourceList{Resources: used}
+	}
+}
+
+// computeElasticAllocation resolves the min/max borrowing model across all
+// queues: a queue below its Min always keeps Min; a queue above its Min may
+// borrow the slack that sibling queues are not using, up to its own Max.
+func (qm *quotaManager) computeElasticAllocation(queues []arbv1.Queue) {
+	for _, resourceName := range elasticResourceNames(queues) {
+		var pool resource.Quantity
+		type borrower struct {
+			queue  *arbv1.Queue
+			demand resource.Quantity
+		}
+		var borrowers []borrower
+
+		for i := range queues {
+			queue := &queues[i]
+			min := quantityOf(queue.Spec.Min.Resources, resourceName)
+			max := quantityOf(queue.Spec.Max.Resources, resourceName)
+			used := quantityOf(queue.Status.Used.Resources, resourceName)
+
+			if max.Cmp(min) <= 0 {
+				// No elastic range declared for this queue/resource; leave
+				// Status.Allocated untouched so legacy single-value queues
+				// keep working exactly as before.
+				continue
+			}
+
+			// Every queue with an elastic range starts from its Min with
+			// nothing borrowed; borrowers are raised above it below.
+			setQuantity(&queue.Status.Allocated, resourceName, min)
+			setQuantity(&queue.Status.Min, resourceName, min)
+			setQuantity(&queue.Status.Max, resourceName, max)
+			setQuantity(&queue.Status.Borrowed, resourceName, resource.Quantity{})
+
+			if used.Cmp(min) < 0 {
+				idle := min.DeepCopy()
+				idle.Sub(used)
+				pool.Add(idle)
+			} else {
+				demand := used.DeepCopy()
+				demand.Sub(min)
+				room := max.DeepCopy()
+				room.Sub(min)
+				if demand.Cmp(room) > 0 {
+					demand = room
+				}
+				if demand.Sign() > 0 {
+					borrowers = append(borrowers, borrower{queue: queue, demand: demand})
+				}
+			}
+		}
+
+		var totalDemand resource.Quantity
+		for _, b := range borrowers {
+			totalDemand.Add(b.demand)
+		}
+
+		for _, b := range borrowers {
+			borrowed := b.demand
+			if totalDemand.Cmp(pool) > 0 && totalDemand.Sign() > 0 {
+				// Pool is oversubscribed: scale every borrower's share down
+				// proportionally to its demand.
+				share := float64(pool.MilliValue()) * (float64(b.demand.MilliValue()) / float64(totalDemand.MilliValue()))
+				borrowed = *resource.NewMilliQuantity(int64(share), b.demand.Format)
+			}
+
+			min := quantityOf(b.queue.Spec.Min.Resources, resourceName)
+			allocated := min.DeepCopy()
+			allocated.Add(borrowed)
+			if max := quantityOf(b.queue.Spec.Max.Resources, resourceName); allocated.Cmp(max) > 0 {
+				allocated = max
+			}
+
+			setQuantity(&b.queue.Status.Allocated, resourceName, allocated)
+			setQuantity(&b.queue.Status.Min, resourceName, min)
+			setQuantity(&b.queue.Status.Max, resourceName, quantityOf(b.queue.Spec.Max.Resources, resourceName))
+			setQuantity(&b.queue.Status.Borrowed, resourceName, borrowed)
+		}
+	}
+}
+
+// projectAllocatedResources writes every resource the queue was allocated
+// onto the ResourceQuota's hard limits, using `limits.<name>` for compute
+// resources (cpu/memory) and `requests.<name>` for everything else
+// (extended resources such as nvidia.com/gpu, hugepages, or scalar
+// resources declared by a CRD), matching the conventions the built-in
+// ResourceQuota admission plugin expects.
+func projectAllocatedResources(rq *v1.ResourceQuota, allocated map[string]resource.Quantity) {
+	for name, quantity := range allocated {
+		if computeResources[name] {
+			rq.Spec.Hard[v1.ResourceName("limits."+name)] = quantity
+			rq.Spec.Hard[v1.ResourceName("requests."+name)] = quantity
 			continue
 		}
+		rq.Spec.Hard[v1.ResourceName("requests."+name)] = quantity
+	}
+}
+
+func quantityOf(resources map[string]resource.Quantity, name string) resource.Quantity {
+	if resources == nil {
+		return resource.Quantity{}
+	}
+	return resources[name]
+}
+
+func setQuantity(rl *arbv1.ResourceList, name string, q resource.Quantity) {
+	if rl.Resources == nil {
+		rl.Resources = map[string]resource.Quantity{}
+	}
+	rl.Resources[name] = q
+}
+
+// queueNode is one entry of the in-memory queue tree built fresh on every
+// reconciliation pass.
+type queueNode struct {
+	queue    *arbv1.Queue
+	children []*queueNode
+}
+
+// applyHierarchy builds the parent/child queue tree, detects cycles, and
+// walks it top-down, splitting each parent's allocation among its children
+// proportional to their Weight. A child's share is raised to its own Min,
+// even where that takes it past what its parent can back, and capped at its
+// Max; capacity a capped child does not take is not passed to its siblings.
+func (qm *quotaManager) applyHierarchy(queues []arbv1.Queue) {
+	byName := make(map[string]*queueNode, len(queues))
+	for i := range queues {
+		byName[queues[i].Name] = &queueNode{queue: &queues[i]}
+	}
+
+	var roots []*queueNode
+	for _, node := range byName {
+		parentName := node.queue.Spec.Parent
+		if parentName == "" {
+			roots = append(roots, node)
+			continue
+		}
+		if qm.hasCycle(byName, node.queue.Name) {
+			glog.Errorf("Queue %s has a cyclic parent chain, treating it as a root", node.queue.Name)
+			qm.setOvercommitCondition(node.queue, "CyclicParent", "queue's parent chain forms a cycle; treated as a root queue")
+			roots = append(roots, node)
+			continue
+		}
+		parent, ok := byName[parentName]
+		if !ok {
+			glog.Errorf("Queue %s references unknown parent %s, treating it as a root", node.queue.Name, parentName)
+			roots = append(roots, node)
+			continue
+		}
+		parent.children = append(parent.children, node)
+	}
+
+	for _, resourceName := range elasticResourceNames(queues) {
+		for _, root := range roots {
+			available := quantityOf(root.queue.Status.Allocated.Resources, resourceName)
+			qm.distribute(root, resourceName, available)
+		}
+	}
+}
+
+// hasCycle walks the Parent chain starting at name and reports whether it
+// loops back on itself before reaching a queue with no parent.
+func (qm *quotaManager) hasCycle(byName map[string]*queueNode, name string) bool {
+	visited := map[string]bool{}
+	for {
+		visited[name] = true
+		node, ok := byName[name]
+		if !ok {
+			return false
+		}
+		parent := node.queue.Spec.Parent
+		if parent == "" {
+			return false
+		}
+		if visited[parent] {
+			return true
+		}
+		name = parent
+	}
+}
+
+// distribute hands `available` down to node's children (capped at each
+// child's own Max, shared proportional to Weight), recording an Overcommit
+// condition when the children collectively ask for more than is available.
+func (qm *quotaManager) distribute(node *queueNode, resourceName string, available resource.Quantity) {
+	if len(node.children) == 0 {
+		return
+	}
+
+	var demand resource.Quantity
+	for _, child := range node.children {
+		demand.Add(quantityOf(child.queue.Spec.Max.Resources, resourceName))
+	}
+
+	if demand.Cmp(available) > 0 {
+		qm.setOvercommitCondition(node.queue, "ChildrenOvercommitParent",
+			"sum of children's max exceeds this queue's allocated capacity; slack is being shared proportionally")
+	}
+
+	var totalWeight int64
+	for _, child := range node.children {
+		w := child.queue.Spec.Weight
+		if w <= 0 {
+			w = 1
+		}
+		totalWeight += int64(w)
+	}
+
+	for _, child := range node.children {
+		w := child.queue.Spec.Weight
+		if w <= 0 {
+			w = 1
+		}
+
+		share := available.DeepCopy()
+		if totalWeight > 0 {
+			share = *resource.NewMilliQuantity(available.MilliValue()*int64(w)/totalWeight, available.Format)
+		}
+
+		// A child is always entitled to its own Min, the same guarantee
+		// computeElasticAllocation makes for a flat (non-hierarchical)
+		// queue; never let proportional sharing claw back below that floor.
+		min := quantityOf(child.queue.Spec.Min.Resources, resourceName)
+		if share.Cmp(min) < 0 {
+			share = min
+		}
+
+		max := quantityOf(child.queue.Spec.Max.Resources, resourceName)
+		if max.Sign() > 0 && share.Cmp(max) > 0 {
+			share = max
+		}
+
+		setQuantity(&child.queue.Status.Allocated, resourceName, share)
+		setQuantity(&child.queue.Status.Min, resourceName, min)
+		setQuantity(&child.queue.Status.Max, resourceName, max)
+
+		borrowed := share.DeepCopy()
+		borrowed.Sub(min)
+		if borrowed.Sign() < 0 {
+			borrowed = resource.Quantity{}
+		}
+		setQuantity(&child.queue.Status.Borrowed, resourceName, borrowed)
+
+		qm.distribute(child, resourceName, share)
 	}
 }
 
+// setOvercommitCondition records (or refreshes) the Overcommit condition on
+// a queue's status so schedulers/users can see the hierarchy is stretched.
+func (qm *quotaManager) setOvercommitCondition(queue *arbv1.Queue, reason, message string) {
+	for i := range queue.Status.Conditions {
+		if queue.Status.Conditions[i].Type == arbv1.QueueConditionOvercommit {
+			queue.Status.Conditions[i].Status = v1.ConditionTrue
+			queue.Status.Conditions[i].Reason = reason
+			queue.Status.Conditions[i].Message = message
+			return
+		}
+	}
+	queue.Status.Conditions = append(queue.Status.Conditions, arbv1.QueueCondition{
+		Type:    arbv1.QueueConditionOvercommit,
+		Status:  v1.ConditionTrue,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// updateQueueStatus pushes the reconciled Used/Min/Max/Borrowed/Allocated
+// vectors back onto the Queue's status subresource.
+func (qm *quotaManager) updateQueueStatus(queue *arbv1.Queue) error {
+	queueClient, _, err := client.NewClient(qm.config)
+	if err != nil {
+		return err
+	}
+
+	return queueClient.Put().
+		Resource(arbv1.QueuePlural).
+		Namespace(queue.Namespace).
+		Name(queue.Name).
+		SubResource("status").
+		Body(queue).
+		Do().
+		Error()
+}
+
+// AddQueue makes sure every namespace a new queue backs (its own
+// namespace, or every namespace matched by NamespaceSelector) has a
+// zero-valued managed quota waiting for the next reconcile to size it.
+// Owner references on quotas we create make cleanup automatic once the
+// queue is deleted, instead of the old single-quota-per-namespace
+// assumption.
 func (qm *quotaManager) AddQueue(obj interface{}) {
 	queue, ok := obj.(*arbv1.Queue)
 	if !ok {
@@ -142,37 +843,32 @@ func (qm *quotaManager) AddQueue(obj interface{}) {
 	}
 
 	cs := kubernetes.NewForConfigOrDie(qm.config)
-	rqController := cs.CoreV1().ResourceQuotas(queue.Namespace)
 
-	rqList, err := rqController.List(meta_v1.ListOptions{})
-	if err != nil || len(rqList.Items) > 0 {
-		glog.V(4).Infof("There are %d quotas under namespace %s, queue %s, err %#v", len(rqList.Items), queue.Namespace, queue.Name, err)
+	namespaces, err := qm.targetNamespaces(cs, queue)
+	if err != nil {
+		glog.Errorf("Failed to resolve target namespaces for queue %s, %#v", queue.Name, err)
 		return
 	}
 
-	// create a default quota for the queue
-	// new quota name like "quota-QueueName"
-	newRq := &v1.ResourceQuota{
-		ObjectMeta: meta_v1.ObjectMeta{
-			Name:      "quota-" + queue.Name,
-			Namespace: queue.Namespace,
-		},
-		Spec: v1.ResourceQuotaSpec{
-			Hard: map[v1.ResourceName]resource.Quantity{
-				"limits.cpu":      resource.MustParse("0"),
-				"requests.cpu":    resource.MustParse("0"),
-				"limits.memory":   resource.MustParse("0"),
-				"requests.memory": resource.MustParse("0"),
-			},
-		},
-	}
+	name := quotaNameFor(queue)
+	for _, ns := range namespaces {
+		rqController := cs.CoreV1().ResourceQuotas(ns.Name)
+		if _, err := rqController.Get(name, meta_v1.GetOptions{}); err == nil {
+			// Already exists, either managed by us from a previous pass or
+			// awaiting adoption; leave it for reconcileNamespaceQuota.
+			continue
+		} else if !apierrors.IsNotFound(err) {
+			glog.Errorf("Failed to get resource quota %s/%s, %#v", ns.Name, name, err)
+			continue
+		}
 
-	_, err = rqController.Create(newRq)
-	if err != nil {
-		glog.Errorf("Failed to create resource quota %s, %#v", newRq.Name, err)
+		if err := qm.createManagedQuota(rqController, queue, name, map[string]resource.Quantity{
+			"cpu":    resource.MustParse("0"),
+			"memory": resource.MustParse("0"),
+		}); err != nil {
+			glog.Errorf("Failed to create resource quota %s/%s, %#v", ns.Name, name, err)
+		}
 	}
-
-	return
 }
 
 func (qm *quotaManager) DeleteQueue(obj interface{}) {
@@ -192,18 +888,32 @@ func (qm *quotaManager) DeleteQueue(obj interface{}) {
 		return
 	}
 
-	// delete the quota for the queue
+	// Quotas we created carry an owner reference to this queue, so the
+	// API server's garbage collector removes them on its own; we only
+	// need to clean up quotas that predate owner-reference support.
 	cs := kubernetes.NewForConfigOrDie(qm.config)
-	rqController := cs.CoreV1().ResourceQuotas(queue.Namespace)
-
-	rqList, err := rqController.List(meta_v1.ListOptions{})
-	if err != nil || len(rqList.Items) != 1 {
-		glog.V(4).Infof("There are %d quotas under namespace %s, queue %s, err %#v", queue.Namespace, queue.Name, err)
+	namespaces, err := qm.targetNamespaces(cs, queue)
+	if err != nil {
+		glog.Errorf("Failed to resolve target namespaces for queue %s, %#v", queue.Name, err)
 		return
 	}
 
-	err = rqController.Delete(rqList.Items[0].Name, &meta_v1.DeleteOptions{})
-	if err != nil {
-		glog.Errorf("Failed to delete resource quota %s, %#v", rqList.Items[0].Name, err)
+	name := quotaNameFor(queue)
+	for _, ns := range namespaces {
+		rqController := cs.CoreV1().ResourceQuotas(ns.Name)
+		rq, err := rqController.Get(name, meta_v1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			glog.Errorf("Failed to get resource quota %s/%s, %#v", ns.Name, name, err)
+			continue
+		}
+		if len(rq.OwnerReferences) > 0 {
+			continue
+		}
+		if err := rqController.Delete(name, &meta_v1.DeleteOptions{}); err != nil {
+			glog.Errorf("Failed to delete resource quota %s/%s, %#v", ns.Name, name, err)
+		}
 	}
 }
@@ -0,0 +1,150 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	arbv1 "github.com/kubernetes-incubator/kube-arbitrator/pkg/apis/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func minMaxQueue(name string, min, max int64) arbv1.Queue {
+	return arbv1.Queue{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: name},
+		Spec: arbv1.QueueSpec{
+			Min: arbv1.ResourceList{Resources: map[string]resource.Quantity{"cpu": *resource.NewQuantity(min, resource.DecimalSI)}},
+			Max: arbv1.ResourceList{Resources: map[string]resource.Quantity{"cpu": *resource.NewQuantity(max, resource.DecimalSI)}},
+		},
+		Status: arbv1.QueueStatus{
+			Used: arbv1.ResourceList{Resources: map[string]resource.Quantity{}},
+		},
+	}
+}
+
+func TestComputeElasticAllocationBorrowsIdleCapacity(t *testing.T) {
+	qm := &quotaManager{}
+
+	idle := minMaxQueue("idle", 4, 10)
+	idle.Status.Used = arbv1.ResourceList{Resources: map[string]resource.Quantity{"cpu": *resource.NewQuantity(1, resource.DecimalSI)}}
+
+	borrower := minMaxQueue("borrower", 2, 10)
+	borrower.Status.Used = arbv1.ResourceList{Resources: map[string]resource.Quantity{"cpu": *resource.NewQuantity(5, resource.DecimalSI)}}
+
+	queues := []arbv1.Queue{idle, borrower}
+	qm.computeElasticAllocation(queues)
+
+	// idle queue has 3 cpu of slack (min 4, used 1); borrower wants 3 more
+	// cpu on top of its min of 2 (used 5, min 2) and isn't capped by its
+	// max of 10, so it should borrow the full pool.
+	got := queues[1].Status.Allocated.Resources["cpu"]
+	want := resource.NewQuantity(5, resource.DecimalSI)
+	if got.Cmp(*want) != 0 {
+		t.Errorf("borrower allocated = %s, want %s", got.String(), want.String())
+	}
+}
+
+func TestComputeElasticAllocationScalesDownWhenOversubscribed(t *testing.T) {
+	qm := &quotaManager{}
+
+	idle := minMaxQueue("idle", 4, 10)
+	idle.Status.Used = arbv1.ResourceList{Resources: map[string]resource.Quantity{"cpu": *resource.NewQuantity(3, resource.DecimalSI)}}
+	// Only 1 cpu of slack available.
+
+	borrowerA := minMaxQueue("a", 2, 20)
+	borrowerA.Status.Used = arbv1.ResourceList{Resources: map[string]resource.Quantity{"cpu": *resource.NewQuantity(6, resource.DecimalSI)}}
+	// Demands 4 cpu beyond its Min.
+
+	borrowerB := minMaxQueue("b", 2, 20)
+	borrowerB.Status.Used = arbv1.ResourceList{Resources: map[string]resource.Quantity{"cpu": *resource.NewQuantity(6, resource.DecimalSI)}}
+	// Also demands 4 cpu beyond its Min.
+
+	queues := []arbv1.Queue{idle, borrowerA, borrowerB}
+	qm.computeElasticAllocation(queues)
+
+	// Total demand (8) exceeds the 1 cpu pool, so each borrower's share is
+	// scaled proportionally to its demand; with equal demand, each gets
+	// half the pool (0.5 cpu) on top of its Min of 2.
+	for _, q := range queues[1:] {
+		allocated := q.Status.Allocated.Resources["cpu"]
+		if allocated.Cmp(*resource.NewMilliQuantity(2500, resource.DecimalSI)) != 0 {
+			t.Errorf("queue %s allocated = %s, want 2500m", q.Name, allocated.String())
+		}
+	}
+}
+
+func TestApplyHierarchyFloorsChildAtMin(t *testing.T) {
+	qm := &quotaManager{}
+
+	parent := minMaxQueue("parent", 0, 10)
+	parent.Status.Allocated = arbv1.ResourceList{Resources: map[string]resource.Quantity{"cpu": *resource.NewQuantity(10, resource.DecimalSI)}}
+
+	// Two children whose combined weighted share of 10 cpu would normally
+	// leave "starved" well under its own Min of 4.
+	busy := minMaxQueue("busy", 1, 20)
+	busy.Spec.Parent = "parent"
+	busy.Spec.Weight = 9
+
+	starved := minMaxQueue("starved", 4, 20)
+	starved.Spec.Parent = "parent"
+	starved.Spec.Weight = 1
+
+	queues := []arbv1.Queue{parent, busy, starved}
+	qm.applyHierarchy(queues)
+
+	for _, q := range queues {
+		if q.Name != "starved" {
+			continue
+		}
+		allocated := q.Status.Allocated.Resources["cpu"]
+		min := q.Spec.Min.Resources["cpu"]
+		if allocated.Cmp(min) < 0 {
+			t.Fatalf("starved child allocated %s, below its own Min %s", allocated.String(), min.String())
+		}
+		statusMin := q.Status.Min.Resources["cpu"]
+		if statusMin.Cmp(min) != 0 {
+			t.Errorf("starved child Status.Min = %s, want %s", statusMin.String(), min.String())
+		}
+	}
+}
+
+func TestApplyHierarchyDetectsCycle(t *testing.T) {
+	qm := &quotaManager{}
+
+	a := minMaxQueue("a", 1, 5)
+	a.Spec.Parent = "b"
+	b := minMaxQueue("b", 1, 5)
+	b.Spec.Parent = "a"
+
+	queues := []arbv1.Queue{a, b}
+	// Neither queue's parent chain terminates at a root, so both get
+	// treated as roots instead of infinite-looping or panicking.
+	qm.applyHierarchy(queues)
+
+	for _, q := range queues {
+		found := false
+		for _, c := range q.Status.Conditions {
+			if c.Type == arbv1.QueueConditionOvercommit && c.Reason == "CyclicParent" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("queue %s in a cyclic parent chain should carry a CyclicParent Overcommit condition", q.Name)
+		}
+	}
+}
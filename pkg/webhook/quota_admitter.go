@@ -0,0 +1,299 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package webhook serves a validating admission webhook that enforces
+// Queue quotas at pod-creation time, rather than the reconciler's
+// best-effort, post-hoc ResourceQuota update.
+//
+// TODO(houzhizhen/volcano#chunk0-6): PodGroup creates are not enforced.
+// The original request asked for "Pod (and PodGroup) creates" to be
+// covered; admit() only understands corev1.Pod today; and decoding a
+// PodGroup's Raw bytes into a Pod silently admitted every PodGroup as if
+// it requested nothing, so the "podgroups" rule was dropped from
+// deploy/webhook/manifests.yaml rather than ship that hole. Re-add the
+// rule once admit() can decode a PodGroup and sum its own resource ask.
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	arbv1 "github.com/kubernetes-incubator/kube-arbitrator/pkg/apis/v1"
+	arbclient "github.com/kubernetes-incubator/kube-arbitrator/pkg/client/informers/v1"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+)
+
+var (
+	scheme = runtime.NewScheme()
+	codecs = serializer.NewCodecFactory(scheme)
+)
+
+func init() {
+	if err := admissionv1beta1.AddToScheme(scheme); err != nil {
+		panic(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		panic(err)
+	}
+}
+
+// reservation is a short-lived hold against a queue's budget, covering the
+// gap between this webhook admitting a pod and the next informer sync
+// observing that pod as Used.
+type reservation struct {
+	resources map[string]resource.Quantity
+	expiresAt time.Time
+}
+
+// QuotaAdmitter is a validating admission webhook that rejects Pod creates
+// that would push their Queue's usage past its Status.Allocated, using the
+// same queue informer/cache the quotaManager reconciler reads so the two
+// never disagree about what a queue currently holds.
+type QuotaAdmitter struct {
+	queueInformer     arbclient.QueueInformer
+	namespaceInformer coreinformers.NamespaceInformer
+	reservationTTL    time.Duration
+
+	mu                  sync.Mutex
+	reservationsByQueue map[string][]reservation
+}
+
+// NewQuotaAdmitter builds a QuotaAdmitter sharing queueInformer's cache with
+// the reconciler, and namespaceInformer's cache to resolve queues that back
+// a namespace via NamespaceSelector rather than Namespace. reservationTTL
+// bounds how long an admitted pod's request is counted against the queue
+// before the next informer sync should have observed it for real.
+func NewQuotaAdmitter(queueInformer arbclient.QueueInformer, namespaceInformer coreinformers.NamespaceInformer, reservationTTL time.Duration) *QuotaAdmitter {
+	return &QuotaAdmitter{
+		queueInformer:       queueInformer,
+		namespaceInformer:   namespaceInformer,
+		reservationTTL:      reservationTTL,
+		reservationsByQueue: map[string][]reservation{},
+	}
+}
+
+// ReadyzHandler reports healthy only once the shared queue cache has
+// synced, so the webhook doesn't reject (or blindly admit) pods before it
+// has any queues to check requests against.
+func (a *QuotaAdmitter) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.queueInformer.Informer().HasSynced() {
+		http.Error(w, "queue cache not synced", http.StatusServiceUnavailable)
+		return
+	}
+	if !a.namespaceInformer.Informer().HasSynced() {
+		http.Error(w, "namespace cache not synced", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// ServeHTTP implements the AdmissionReview request/response contract the
+// API server's webhook client speaks.
+func (a *QuotaAdmitter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	review := admissionv1beta1.AdmissionReview{}
+	if _, _, err := codecs.UniversalDeserializer().Decode(body, nil, &review); err != nil {
+		glog.Errorf("Failed to decode admission review, %#v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	review.Response = a.admit(review.Request)
+	review.Response.UID = review.Request.UID
+
+	respBytes, err := json.Marshal(review)
+	if err != nil {
+		glog.Errorf("Failed to encode admission review response, %#v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(respBytes); err != nil {
+		glog.Errorf("Failed to write admission review response, %#v", err)
+	}
+}
+
+func (a *QuotaAdmitter) admit(req *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	if req.Resource.Group != "" || req.Resource.Resource != "pods" {
+		// Only the "pods" CREATE rule in deploy/webhook/manifests.yaml routes
+		// here; anything else reaching this handler is a misconfigured
+		// webhook rule, not a request we know how to enforce quota against.
+		glog.Errorf("Admission request for unexpected resource %#v; allowing", req.Resource)
+		return allow()
+	}
+
+	pod := corev1.Pod{}
+	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
+		return deny(fmt.Sprintf("failed to decode pod: %v", err))
+	}
+
+	queue := a.findQueue(req.Namespace)
+	if queue == nil {
+		// Namespace isn't owned by any Queue; nothing for us to enforce.
+		return allow()
+	}
+
+	requested := sumPodRequests(&pod)
+	projected := addResources(addResources(queue.Status.Used.Resources, a.reservedFor(queue.Name)), requested)
+
+	for name, quantity := range projected {
+		allocated, ok := queue.Status.Allocated.Resources[name]
+		if !ok {
+			continue
+		}
+		if quantity.Cmp(allocated) > 0 {
+			return deny(fmt.Sprintf("pod %s/%s would push queue %s's %s usage to %s, over its allocated %s",
+				pod.Namespace, pod.Name, queue.Name, name, quantity.String(), allocated.String()))
+		}
+	}
+
+	a.reserve(queue.Name, requested)
+	return allow()
+}
+
+// findQueue returns the Queue that owns namespace, if any. Queues backing
+// several namespaces via a NamespaceSelector are matched the same way the
+// reconciler's targetNamespaces does: against the namespace's own labels,
+// read from the shared namespace informer cache.
+func (a *QuotaAdmitter) findQueue(namespace string) *arbv1.Queue {
+	var nsLabels labels.Set
+	nsObj, exists, err := a.namespaceInformer.Informer().GetStore().GetByKey(namespace)
+	if err != nil {
+		glog.Errorf("Failed to look up namespace %s in cache, %#v", namespace, err)
+	} else if exists {
+		if ns, ok := nsObj.(*corev1.Namespace); ok {
+			nsLabels = ns.Labels
+		}
+	}
+
+	for _, obj := range a.queueInformer.Informer().GetStore().List() {
+		queue, ok := obj.(*arbv1.Queue)
+		if !ok {
+			continue
+		}
+
+		if queue.Spec.NamespaceSelector == nil {
+			if queue.Namespace == namespace {
+				return queue
+			}
+			continue
+		}
+
+		selector, err := meta_v1.LabelSelectorAsSelector(queue.Spec.NamespaceSelector)
+		if err != nil {
+			glog.Errorf("Queue %s has an invalid NamespaceSelector, %#v", queue.Name, err)
+			continue
+		}
+		if selector.Matches(nsLabels) {
+			return queue
+		}
+	}
+	return nil
+}
+
+func (a *QuotaAdmitter) reserve(queueName string, requested map[string]resource.Quantity) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expireLocked(queueName)
+	a.reservationsByQueue[queueName] = append(a.reservationsByQueue[queueName], reservation{
+		resources: requested,
+		expiresAt: time.Now().Add(a.reservationTTL),
+	})
+}
+
+// reservedFor sums the still-live reservations held against queueName,
+// pruning any that have outlived their TTL first.
+func (a *QuotaAdmitter) reservedFor(queueName string) map[string]resource.Quantity {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expireLocked(queueName)
+
+	total := map[string]resource.Quantity{}
+	for _, r := range a.reservationsByQueue[queueName] {
+		for name, quantity := range r.resources {
+			cur := total[name]
+			cur.Add(quantity)
+			total[name] = cur
+		}
+	}
+	return total
+}
+
+func (a *QuotaAdmitter) expireLocked(queueName string) {
+	now := time.Now()
+	live := a.reservationsByQueue[queueName][:0]
+	for _, r := range a.reservationsByQueue[queueName] {
+		if r.expiresAt.After(now) {
+			live = append(live, r)
+		}
+	}
+	a.reservationsByQueue[queueName] = live
+}
+
+func sumPodRequests(pod *corev1.Pod) map[string]resource.Quantity {
+	total := map[string]resource.Quantity{}
+	for _, c := range pod.Spec.Containers {
+		for name, quantity := range c.Resources.Requests {
+			cur := total[string(name)]
+			cur.Add(quantity)
+			total[string(name)] = cur
+		}
+	}
+	return total
+}
+
+func addResources(a, b map[string]resource.Quantity) map[string]resource.Quantity {
+	out := make(map[string]resource.Quantity, len(a)+len(b))
+	for name, quantity := range a {
+		out[name] = quantity
+	}
+	for name, quantity := range b {
+		cur := out[name]
+		cur.Add(quantity)
+		out[name] = cur
+	}
+	return out
+}
+
+func allow() *admissionv1beta1.AdmissionResponse {
+	return &admissionv1beta1.AdmissionResponse{Allowed: true}
+}
+
+func deny(message string) *admissionv1beta1.AdmissionResponse {
+	return &admissionv1beta1.AdmissionResponse{
+		Allowed: false,
+		Result:  &meta_v1.Status{Message: message},
+	}
+}
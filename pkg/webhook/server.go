@@ -0,0 +1,43 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"net/http"
+)
+
+// NewServer wires a QuotaAdmitter behind /validate-pods, the path the
+// ValidatingWebhookConfiguration in deploy/webhook/ points at, and serves
+// ReadyzHandler on /readyz for a readiness probe; the manifests in
+// deploy/webhook/ do not configure that probe.
+func NewServer(admitter *QuotaAdmitter, addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/validate-pods", admitter)
+	mux.HandleFunc("/readyz", admitter.ReadyzHandler)
+
+	return &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+}
+
+// ListenAndServeTLS starts srv using the tls.crt/tls.key pair
+// cert-manager writes into the Secret mounted onto the controller pod,
+// blocking until the server stops or errors.
+func ListenAndServeTLS(srv *http.Server, certFile, keyFile string) error {
+	return srv.ListenAndServeTLS(certFile, keyFile)
+}